@@ -0,0 +1,97 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ovh/cds/sdk"
+)
+
+// cacheTTL is how long a LoadAllByType result - positive or negative - stays valid
+// before the next call falls through to the database.
+const cacheTTL = 10 * time.Second
+
+type cacheEntry struct {
+	services  []sdk.Service
+	expiresAt time.Time
+}
+
+// serviceCache memoises LoadAllByType results per service type, including a short
+// negative-cache entry for types with no services.
+type serviceCache struct {
+	mu      sync.RWMutex
+	entries map[string]cacheEntry
+
+	hits          prometheus.Counter
+	misses        prometheus.Counter
+	invalidations prometheus.Counter
+}
+
+// newServiceCache builds a cache with its own, unregistered set of counters - tests can
+// construct as many as they like without clashing on the Prometheus default registry.
+func newServiceCache() *serviceCache {
+	return &serviceCache{
+		entries: make(map[string]cacheEntry),
+		hits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "cds",
+			Subsystem: "services",
+			Name:      "cache_hits_total",
+			Help:      "Number of LoadAllByType calls served from cache.",
+		}),
+		misses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "cds",
+			Subsystem: "services",
+			Name:      "cache_misses_total",
+			Help:      "Number of LoadAllByType calls that fell through to the database.",
+		}),
+		invalidations: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "cds",
+			Subsystem: "services",
+			Name:      "cache_invalidations_total",
+			Help:      "Number of times a service type was invalidated from the cache.",
+		}),
+	}
+}
+
+var internalCache = newServiceCache()
+
+func init() {
+	prometheus.MustRegister(internalCache.hits, internalCache.misses, internalCache.invalidations)
+}
+
+// getFromCache returns the cached services for stype, if any entry is present and
+// hasn't expired - an empty, non-nil slice is a valid (negative) cache hit.
+func (c *serviceCache) getFromCache(stype string) ([]sdk.Service, bool) {
+	c.mu.RLock()
+	e, ok := c.entries[stype]
+	c.mu.RUnlock()
+
+	if !ok || time.Now().After(e.expiresAt) {
+		c.misses.Inc()
+		return nil, false
+	}
+	c.hits.Inc()
+	return e.services, true
+}
+
+// setCache stores ss - possibly empty - as the cached result for stype.
+func (c *serviceCache) setCache(stype string, ss []sdk.Service) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[stype] = cacheEntry{services: ss, expiresAt: time.Now().Add(cacheTTL)}
+}
+
+// invalidate drops the cached entry for stype, if any.
+func (c *serviceCache) invalidate(stype string) {
+	if stype == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[stype]; ok {
+		delete(c.entries, stype)
+		c.invalidations.Inc()
+	}
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ovh/cds/sdk"
+)
+
+func TestServiceCache_NegativeCaching(t *testing.T) {
+	c := newServiceCache()
+
+	_, ok := c.getFromCache("hatchery")
+	assert.False(t, ok)
+
+	c.setCache("hatchery", []sdk.Service{})
+
+	ss, ok := c.getFromCache("hatchery")
+	require.True(t, ok)
+	assert.Empty(t, ss)
+}
+
+func TestServiceCache_Invalidate(t *testing.T) {
+	c := newServiceCache()
+	c.setCache("hatchery", []sdk.Service{{Name: "hatchery-1"}})
+
+	c.invalidate("hatchery")
+
+	_, ok := c.getFromCache("hatchery")
+	assert.False(t, ok)
+}
+
+func TestServiceCache_ExpiredEntryIsAMiss(t *testing.T) {
+	c := newServiceCache()
+	c.setCache("hatchery", []sdk.Service{{Name: "hatchery-1"}})
+	c.entries["hatchery"] = cacheEntry{
+		services:  c.entries["hatchery"].services,
+		expiresAt: time.Now().Add(-time.Second),
+	}
+
+	_, ok := c.getFromCache("hatchery")
+	assert.False(t, ok)
+}
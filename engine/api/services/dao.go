@@ -2,51 +2,28 @@ package services
 
 import (
 	"context"
+	"errors"
 	"time"
 
-	"github.com/ovh/cds/engine/api/authentication"
-	"github.com/ovh/cds/engine/api/worker"
-
 	"github.com/go-gorp/gorp"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
+	"github.com/ovh/cds/engine/api/authentication"
 	"github.com/ovh/cds/engine/api/database/gorpmapping"
+	"github.com/ovh/cds/engine/api/worker"
 	"github.com/ovh/cds/sdk"
 	"github.com/ovh/cds/sdk/log"
 )
 
-func getAll(ctx context.Context, db gorp.SqlExecutor, q gorpmapping.Query) ([]sdk.Service, error) {
-	ss := []service{}
-
-	if err := gorpmapping.GetAll(ctx, db, q, &ss); err != nil {
-		return nil, sdk.WrapError(err, "cannot get services")
-	}
-
-	// Check signature of data, if invalid do not return it
-	verifiedServices := make([]sdk.Service, 0, len(ss))
-	for i := range ss {
-		isValid, err := gorpmapping.CheckSignature(ss[i], ss[i].Signature)
-		if err != nil {
-			return nil, err
-		}
-		if !isValid {
-			log.Error("service.getAll> service %d data corrupted", ss[i].ID)
-			continue
-		}
-		verifiedServices = append(verifiedServices, ss[i].Service)
-	}
-
-	return verifiedServices, nil
-}
-
-func get(ctx context.Context, db gorp.SqlExecutor, q gorpmapping.Query) (*sdk.Service, error) {
-	var s service
-
-	found, err := gorpmapping.Get(ctx, db, q, &s)
-	if err != nil {
-		return nil, sdk.WrapError(err, "cannot get service")
-	}
-	if !found {
-		return nil, sdk.WithStack(sdk.ErrNotFound) // TODO return no error
+func get(ctx context.Context, db *gorm.DB, scope func(*gorm.DB) *gorm.DB) (*sdk.Service, error) {
+	var s Service
+	err := scope(db.WithContext(ctx)).First(&s).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, sdk.WithStack(ErrServiceNotFound)
+	case err != nil:
+		return nil, ErrServiceStoreFailure.withCause(err)
 	}
 
 	isValid, err := gorpmapping.CheckSignature(s, s.Signature)
@@ -54,116 +31,185 @@ func get(ctx context.Context, db gorp.SqlExecutor, q gorpmapping.Query) (*sdk.Se
 		return nil, err
 	}
 	if !isValid {
-		log.Error("service.get> service %d data corrupted", s.ID)
-		return nil, sdk.WithStack(sdk.ErrNotFound) // TODO return no error
+		log.Error("services.get> service %d data corrupted", s.ID)
+		return nil, sdk.WithStack(ErrServiceDataCorrupted)
 	}
 
-	// TODO why is this code needed ?
+	// A service row with an empty name is a legacy artefact of a partial insert; treat
+	// it the same as a missing row rather than handing out an unusable service.
 	if s.Name == "" {
-		return nil, sdk.WithStack(sdk.ErrNotFound) // TODO return no error
+		return nil, sdk.WithStack(ErrServiceNotFound)
 	}
 
 	return &s.Service, nil
 }
 
 // LoadAll returns all services in database.
-func LoadAll(ctx context.Context, db gorp.SqlExecutor) ([]sdk.Service, error) {
-	query := gorpmapping.NewQuery(`SELECT * FROM services`)
-	return getAll(ctx, db, query)
+func LoadAll(ctx context.Context, db *gorm.DB) ([]sdk.Service, error) {
+	var ss []Service
+	if err := db.WithContext(ctx).Find(&ss).Error; err != nil {
+		return nil, ErrServiceStoreFailure.withCause(err)
+	}
+	return verifyServices(ss), nil
 }
 
 // LoadAllByType returns all services with given type.
-func LoadAllByType(ctx context.Context, db gorp.SqlExecutor, stype string) ([]sdk.Service, error) {
+func LoadAllByType(ctx context.Context, db *gorm.DB, stype string) ([]sdk.Service, error) {
 	if ss, ok := internalCache.getFromCache(stype); ok {
 		return ss, nil
 	}
-	query := gorpmapping.NewQuery(`SELECT * FROM services WHERE type = $1`).Args(stype)
-	return getAll(ctx, db, query)
+	var ss []Service
+	if err := db.WithContext(ctx).Where("type = ?", stype).Find(&ss).Error; err != nil {
+		return nil, ErrServiceStoreFailure.withCause(err)
+	}
+	services := verifyServices(ss)
+	internalCache.setCache(stype, services)
+	return services, nil
 }
 
 // LoadByConsumerID returns a service by its consumer id.
-func LoadByConsumerID(ctx context.Context, db gorp.SqlExecutor, consumerID string) (*sdk.Service, error) {
-	query := gorpmapping.NewQuery("SELECT * FROM services WHERE auth_consumer_id = $1").Args(consumerID)
-	return get(ctx, db, query)
+func LoadByConsumerID(ctx context.Context, db *gorm.DB, consumerID string) (*sdk.Service, error) {
+	return get(ctx, db, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("auth_consumer_id = ?", consumerID)
+	})
 }
 
 // LoadByNameAndType returns a service by its name and type.
-func LoadByNameAndType(ctx context.Context, db gorp.SqlExecutor, name, stype string) (*sdk.Service, error) {
-	query := gorpmapping.NewQuery("SELECT * FROM services WHERE name = $1 and type = $2").Args(name, stype)
-	return get(ctx, db, query)
+func LoadByNameAndType(ctx context.Context, db *gorm.DB, name, stype string) (*sdk.Service, error) {
+	return get(ctx, db, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("name = ? and type = ?", name, stype)
+	})
 }
 
 // LoadByName returns a service by its name.
-func LoadByName(ctx context.Context, db gorp.SqlExecutor, name string) (*sdk.Service, error) {
-	query := gorpmapping.NewQuery("SELECT * FROM services WHERE name = $1").Args(name)
-	return get(ctx, db, query)
+func LoadByName(ctx context.Context, db *gorm.DB, name string) (*sdk.Service, error) {
+	return get(ctx, db, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("name = ?", name)
+	})
 }
 
 // LoadByNameForUpdateAndSkipLocked returns a service by its name.
-func LoadByNameForUpdateAndSkipLocked(ctx context.Context, db gorp.SqlExecutor, name string) (*sdk.Service, error) {
-	query := gorpmapping.NewQuery("SELECT * FROM services WHERE name = $1  FOR UPDATE SKIP LOCKED").Args(name)
-	return get(ctx, db, query)
+func LoadByNameForUpdateAndSkipLocked(ctx context.Context, db *gorm.DB, name string) (*sdk.Service, error) {
+	return get(ctx, db, func(tx *gorm.DB) *gorm.DB {
+		return tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).Where("name = ?", name)
+	})
 }
 
 // LoadByID returns a service by its id.
-func LoadByID(ctx context.Context, db gorp.SqlExecutor, id int64) (*sdk.Service, error) {
-	query := gorpmapping.NewQuery("SELECT * FROM services WHERE id = $1").Args(id)
-	return get(ctx, db, query)
+func LoadByID(ctx context.Context, db *gorm.DB, id int64) (*sdk.Service, error) {
+	return get(ctx, db, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("id = ?", id)
+	})
 }
 
 // FindDeadServices returns services which haven't heart since th duration
-func FindDeadServices(ctx context.Context, db gorp.SqlExecutor, t time.Duration) ([]sdk.Service, error) {
-	query := gorpmapping.NewQuery(`SELECT * FROM services WHERE last_heartbeat < $1`).Args(time.Now().Add(-1 * t))
-	return getAll(ctx, db, query)
+func FindDeadServices(ctx context.Context, db *gorm.DB, t time.Duration) ([]sdk.Service, error) {
+	var ss []Service
+	if err := db.WithContext(ctx).Where("last_heartbeat < ?", time.Now().Add(-1*t)).Find(&ss).Error; err != nil {
+		return nil, ErrServiceStoreFailure.withCause(err)
+	}
+	dead := verifyServices(ss)
+	dispatcher.dispatchDead(ctx, dead)
+	return dead, nil
 }
 
 // Insert a service in database.
-func Insert(db gorp.SqlExecutor, s *sdk.Service) error {
-	sdb := service{Service: *s}
-	if err := gorpmapping.InsertAndSign(db, &sdb); err != nil {
-		return err
+func Insert(db *gorm.DB, s *sdk.Service) error {
+	sdb := Service{Service: *s}
+	if err := db.Create(&sdb).Error; err != nil {
+		return ErrServiceStoreFailure.withCause(err)
 	}
 	*s = sdb.Service
+	internalCache.invalidate(s.Type)
+	dispatcher.dispatchInsert(context.Background(), *s)
 	return nil
 }
 
 // Update a service in database.
-func Update(db gorp.SqlExecutor, s *sdk.Service) error {
-	sdb := service{Service: *s}
-	if err := gorpmapping.UpdatetAndSign(db, &sdb); err != nil {
-		return err
+func Update(db *gorm.DB, s *sdk.Service) error {
+	// Read back the type as it's actually stored, not the incoming *sdk.Service's -
+	// s.Type is already the new value at this point, so comparing against it would
+	// never detect a type change.
+	var previous Service
+	if err := db.Select("type").Where("id = ?", s.ID).First(&previous).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrServiceStoreFailure.withCause(err)
+	}
+	previousType := previous.Type
+
+	sdb := Service{Service: *s}
+	if err := db.Save(&sdb).Error; err != nil {
+		return ErrServiceStoreFailure.withCause(err)
 	}
 	*s = sdb.Service
+
+	internalCache.invalidate(s.Type)
+	if previousType != "" && s.Type != previousType {
+		internalCache.invalidate(previousType)
+	}
+
+	dispatcher.dispatchUpdate(context.Background(), *s)
 	return nil
 }
 
-// Delete a service.
-func Delete(db gorp.SqlExecutor, s *sdk.Service) error {
-	if s.Type == TypeHatchery {
-		wks, err := worker.LoadByHatcheryID(context.Background(), db, s.ID)
+// Delete a service, cascading the removal of its worker and auth consumer rows inside
+// a single Transactional block so a failure mid-cascade can't leave dangling worker or
+// consumer rows behind. db is the same *gorm.DB every other function in this package
+// takes; the worker and authentication packages' DAOs aren't on GORM yet, so Delete
+// borrows the pooled *sql.DB underneath it to drive its own gorp transaction rather
+// than asking callers to hold a *gorp.DbMap alongside their *gorm.DB just for this one
+// call.
+func Delete(db *gorm.DB, s *sdk.Service) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return ErrServiceStoreFailure.withCause(err)
+	}
+	gdb := &gorp.DbMap{Db: sqlDB, Dialect: gorp.PostgresDialect{}}
+
+	err = Transactional(context.Background(), gdb, func(ctx context.Context, tx gorp.SqlExecutor) ([]Service, error) {
+		isValid, err := gorpmapping.CheckSignature(*s, s.Signature)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		if !isValid {
+			return nil, sdk.WithStack(ErrServiceSignatureInvalid)
+		}
+
+		if s.Type == TypeHatchery {
+			wks, err := worker.LoadByHatcheryID(ctx, tx, s.ID)
+			if err != nil {
+				return nil, err
+			}
 
-		for _, wk := range wks {
-			if err := worker.Delete(db, wk.ID); err != nil {
-				return err
+			for _, wk := range wks {
+				if err := worker.Delete(tx, wk.ID); err != nil {
+					return nil, ErrServiceDependentWorkerDelete.withCause(err)
+				}
 			}
 		}
-	}
 
-	if s.ConsumerID != nil {
-		authConsumer, _ := authentication.LoadConsumerByID(context.Background(), db, *s.ConsumerID)
-		if authConsumer != nil {
-			if err := authentication.DeleteConsumerByID(db, authConsumer.ID); err != nil {
-				return err
+		if s.ConsumerID != nil {
+			authConsumer, err := authentication.LoadConsumerByID(ctx, tx, *s.ConsumerID)
+			if err != nil && !errors.Is(err, sdk.ErrNotFound) {
+				return nil, ErrServiceConsumerMissing.withCause(err)
+			}
+			if authConsumer != nil {
+				if err := authentication.DeleteConsumerByID(tx, authConsumer.ID); err != nil {
+					return nil, err
+				}
 			}
 		}
-	}
 
-	sdb := service{Service: *s}
-	if _, err := db.Delete(&sdb); err != nil {
-		return sdk.WrapError(err, "unable to delete service %s", s.Name)
+		if _, err := tx.Exec(`UPDATE services SET deleted_at = now() WHERE id = $1`, s.ID); err != nil {
+			return nil, ErrServiceStoreFailure.withCause(err)
+		}
+
+		return []Service{{Service: *s}}, nil
+	})
+	if err != nil {
+		return err
 	}
+
+	internalCache.invalidate(s.Type)
+	dispatcher.dispatchDelete(context.Background(), *s)
 	return nil
 }
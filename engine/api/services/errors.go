@@ -0,0 +1,65 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Error is a structured error returned by the services package. It carries a stable
+// Code and an HTTP Status so callers (HTTP handlers, hatchery clients) can branch with
+// errors.Is instead of matching on message strings, plus the underlying Cause via
+// errors.Unwrap.
+type Error struct {
+	Code   string
+	Status int
+	Cause  error
+}
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%s: %s", e.Code, e.Cause.Error())
+	}
+	return e.Code
+}
+
+// Unwrap exposes the underlying cause so callers can use errors.As/errors.Is on it.
+func (e *Error) Unwrap() error { return e.Cause }
+
+// Is compares errors by Code so a wrapped *Error still matches its sentinel via
+// errors.Is(err, ErrServiceNotFound), regardless of the Cause it carries.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return e.Code == t.Code
+}
+
+// withCause returns a copy of the sentinel error carrying cause as its Unwrap target.
+func (e *Error) withCause(cause error) *Error {
+	return &Error{Code: e.Code, Status: e.Status, Cause: cause}
+}
+
+// Sentinel errors for the services package. Compare against these with errors.Is,
+// do not compare by message: a corrupted signature and a missing row used to both
+// surface as sdk.ErrNotFound, which hid real data corruption from operators.
+var (
+	// ErrServiceNotFound is returned when no service row matches the query.
+	ErrServiceNotFound = &Error{Code: "WRN_SERVICE_NOTFOUND", Status: http.StatusNotFound}
+	// ErrServiceDataCorrupted is returned when a service row fails its signature check.
+	ErrServiceDataCorrupted = &Error{Code: "WRN_SERVICE_DATA_CORRUPTED", Status: http.StatusInternalServerError}
+	// ErrServiceStoreFailure is returned when a read or write against the services store
+	// fails for infrastructure reasons (connectivity, timeout, a malformed query) rather
+	// than a signature mismatch - keep it distinct from ErrServiceDataCorrupted so an
+	// operator can tell "the store failed" from "this row is corrupted".
+	ErrServiceStoreFailure = &Error{Code: "WRN_SERVICE_STORE_FAILURE", Status: http.StatusInternalServerError}
+	// ErrServiceSignatureInvalid is returned when a row written inside a transaction no
+	// longer verifies before the transaction is committed.
+	ErrServiceSignatureInvalid = &Error{Code: "WRN_SERVICE_SIGNATURE_INVALID", Status: http.StatusInternalServerError}
+	// ErrServiceConsumerMissing is returned when a service's auth consumer could not be
+	// loaded while it was expected to exist.
+	ErrServiceConsumerMissing = &Error{Code: "WRN_SERVICE_CONSUMER_MISSING", Status: http.StatusNotFound}
+	// ErrServiceDependentWorkerDelete is returned when a hatchery's dependent workers
+	// could not be removed as part of a service deletion.
+	ErrServiceDependentWorkerDelete = &Error{Code: "WRN_SERVICE_DEPENDENT_WORKER_DELETE", Status: http.StatusInternalServerError}
+)
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ovh/cds/sdk"
+	"github.com/ovh/cds/sdk/log"
+)
+
+// lifecycleHook is invoked after a service mutation, or a dead-service discovery,
+// completes successfully. It receives a copy of the affected service.
+type lifecycleHook func(ctx context.Context, s sdk.Service)
+
+type deadHook struct {
+	threshold time.Duration
+	hook      lifecycleHook
+}
+
+// maxConcurrentHooks bounds how many lifecycle hooks can run at once.
+const maxConcurrentHooks = 10
+
+type hookDispatcher struct {
+	sem chan struct{}
+
+	mu       sync.RWMutex
+	onInsert []lifecycleHook
+	onUpdate []lifecycleHook
+	onDelete []lifecycleHook
+	onDead   []deadHook
+}
+
+func newHookDispatcher(concurrency int) *hookDispatcher {
+	return &hookDispatcher{sem: make(chan struct{}, concurrency)}
+}
+
+var dispatcher = newHookDispatcher(maxConcurrentHooks)
+
+// run spawns h in its own goroutine and waits for a dispatcher slot from inside that
+// goroutine, not the caller's: the caller must never block on a subscriber, even when
+// all maxConcurrentHooks slots are currently busy.
+func (d *hookDispatcher) run(ctx context.Context, h lifecycleHook, s sdk.Service) {
+	go func() {
+		d.sem <- struct{}{}
+		defer func() { <-d.sem }()
+		defer func() {
+			if r := recover(); r != nil {
+				log.Error("services.hookDispatcher> lifecycle hook panicked: %v", r)
+			}
+		}()
+		h(ctx, s)
+	}()
+}
+
+func (d *hookDispatcher) dispatchInsert(ctx context.Context, s sdk.Service) {
+	d.mu.RLock()
+	hooks := append([]lifecycleHook{}, d.onInsert...)
+	d.mu.RUnlock()
+
+	for _, h := range hooks {
+		d.run(ctx, h, s)
+	}
+}
+
+func (d *hookDispatcher) dispatchUpdate(ctx context.Context, s sdk.Service) {
+	d.mu.RLock()
+	hooks := append([]lifecycleHook{}, d.onUpdate...)
+	d.mu.RUnlock()
+
+	for _, h := range hooks {
+		d.run(ctx, h, s)
+	}
+}
+
+func (d *hookDispatcher) dispatchDelete(ctx context.Context, s sdk.Service) {
+	d.mu.RLock()
+	hooks := append([]lifecycleHook{}, d.onDelete...)
+	d.mu.RUnlock()
+
+	for _, h := range hooks {
+		d.run(ctx, h, s)
+	}
+}
+
+func (d *hookDispatcher) dispatchDead(ctx context.Context, ss []sdk.Service) {
+	d.mu.RLock()
+	deadHooks := append([]deadHook{}, d.onDead...)
+	d.mu.RUnlock()
+
+	for _, dh := range deadHooks {
+		for _, s := range ss {
+			if time.Since(s.LastHeartbeat) < dh.threshold {
+				continue
+			}
+			d.run(ctx, dh.hook, s)
+		}
+	}
+}
+
+// OnInsert registers a hook invoked, with a copy of the new service, after Insert
+// successfully commits.
+func OnInsert(h func(ctx context.Context, s sdk.Service)) {
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+	dispatcher.onInsert = append(dispatcher.onInsert, h)
+}
+
+// OnUpdate registers a hook invoked, with a copy of the updated service, after Update
+// successfully commits.
+func OnUpdate(h func(ctx context.Context, s sdk.Service)) {
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+	dispatcher.onUpdate = append(dispatcher.onUpdate, h)
+}
+
+// OnDelete registers a hook invoked, with a copy of the removed service, after Delete
+// successfully commits - including the worker and auth consumer rows it cascaded.
+func OnDelete(h func(ctx context.Context, s sdk.Service)) {
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+	dispatcher.onDelete = append(dispatcher.onDelete, h)
+}
+
+// OnDead registers a hook invoked, with a copy of the stale service, whenever
+// FindDeadServices discovers a service whose heartbeat is older than d. d is only
+// ever checked against the rows FindDeadServices already queried with its own
+// threshold t, so a hook registered with d < t never fires for services that are
+// stale between d and t - d and t are coupled, not independent.
+func OnDead(d time.Duration, h func(ctx context.Context, s sdk.Service)) {
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+	dispatcher.onDead = append(dispatcher.onDead, deadHook{threshold: d, hook: h})
+}
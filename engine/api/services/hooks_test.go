@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ovh/cds/sdk"
+)
+
+func TestHookDispatcher_DispatchDoesNotBlockCaller(t *testing.T) {
+	d := newHookDispatcher(1)
+
+	block := make(chan struct{})
+	hook := func(ctx context.Context, s sdk.Service) { <-block }
+	d.onInsert = []lifecycleHook{hook, hook}
+
+	done := make(chan struct{})
+	go func() {
+		d.dispatchInsert(context.Background(), sdk.Service{Name: "svc"})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("dispatch blocked the caller instead of handing off to goroutines")
+	}
+
+	close(block)
+}
+
+func TestHookDispatcher_DispatchRacesRegistration(t *testing.T) {
+	d := newHookDispatcher(10)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			d.mu.Lock()
+			d.onInsert = append(d.onInsert, func(ctx context.Context, s sdk.Service) {})
+			d.mu.Unlock()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			d.dispatchInsert(context.Background(), sdk.Service{Name: "svc"})
+		}
+	}()
+	wg.Wait()
+}
+
+func TestHookDispatcher_ConcurrencyBound(t *testing.T) {
+	const concurrency = 3
+	d := newHookDispatcher(concurrency)
+
+	var (
+		mu      sync.Mutex
+		running int
+		maxSeen int
+		wg      sync.WaitGroup
+	)
+	release := make(chan struct{})
+
+	hook := func(ctx context.Context, s sdk.Service) {
+		mu.Lock()
+		running++
+		if running > maxSeen {
+			maxSeen = running
+		}
+		mu.Unlock()
+
+		<-release
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		wg.Done()
+	}
+
+	hooks := make([]lifecycleHook, concurrency*2)
+	for i := range hooks {
+		hooks[i] = hook
+	}
+	wg.Add(len(hooks))
+	d.onInsert = hooks
+
+	d.dispatchInsert(context.Background(), sdk.Service{Name: "svc"})
+
+	// Give every goroutine a chance to start and hit the semaphore before releasing them.
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.LessOrEqual(t, maxSeen, concurrency)
+}
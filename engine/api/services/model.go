@@ -0,0 +1,50 @@
+package services
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/ovh/cds/engine/api/database/gorpmapping"
+	"github.com/ovh/cds/sdk"
+	"github.com/ovh/cds/sdk/log"
+)
+
+// Service is the GORM-backed persistence model for sdk.Service, with a soft-delete
+// column.
+type Service struct {
+	sdk.Service
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-" cli:"-"`
+}
+
+// TableName pins the model to the existing services table.
+func (Service) TableName() string { return "services" }
+
+// BeforeSave signs the row on every insert/update.
+func (s *Service) BeforeSave(tx *gorm.DB) error {
+	return gorpmapping.Sign(s, &s.Signature)
+}
+
+// AutoMigrate creates or updates the services table schema.
+func AutoMigrate(db *gorm.DB) error {
+	return db.AutoMigrate(&Service{})
+}
+
+// verifyServices checks the signature of each row and drops any that don't verify,
+// rather than failing the whole batch - a GORM AfterFind hook would fail the entire
+// Find on one bad row, turning a single corrupted service into an outage for every
+// healthy one sharing its type.
+func verifyServices(ss []Service) []sdk.Service {
+	verified := make([]sdk.Service, 0, len(ss))
+	for i := range ss {
+		isValid, err := gorpmapping.CheckSignature(ss[i], ss[i].Signature)
+		if err != nil {
+			log.Error("services.verifyServices> unable to check signature of service %d: %v", ss[i].ID, err)
+			continue
+		}
+		if !isValid {
+			log.Error("services.verifyServices> service %d data corrupted", ss[i].ID)
+			continue
+		}
+		verified = append(verified, ss[i].Service)
+	}
+	return verified
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"context"
+
+	"github.com/go-gorp/gorp"
+
+	"github.com/ovh/cds/engine/api/database/gorpmapping"
+	"github.com/ovh/cds/sdk"
+	"github.com/ovh/cds/sdk/log"
+)
+
+// TxFunc is run inside Transactional's transaction. It returns the service rows it
+// touched, so Transactional can re-verify their signatures before committing.
+type TxFunc func(ctx context.Context, tx gorp.SqlExecutor) ([]Service, error)
+
+// Transactional runs f inside a database transaction and re-checks the signature of
+// every row f reports having touched before committing, rolling back instead if any of
+// them no longer verifies. The check runs on the values f returns; Transactional does
+// not read those rows back from the database.
+func Transactional(ctx context.Context, db *gorp.DbMap, f TxFunc) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return ErrServiceStoreFailure.withCause(err)
+	}
+	defer tx.Rollback() // nolint
+
+	touched, err := f(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	for i := range touched {
+		isValid, err := gorpmapping.CheckSignature(touched[i], touched[i].Signature)
+		if err != nil {
+			return err
+		}
+		if !isValid {
+			log.Error("services.Transactional> service %d signature invalid before commit", touched[i].ID)
+			return sdk.WithStack(ErrServiceSignatureInvalid)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ErrServiceStoreFailure.withCause(err)
+	}
+	return nil
+}